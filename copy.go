@@ -0,0 +1,202 @@
+package main
+
+import (
+    "fmt"
+    "hash"
+    "io"
+    "log"
+    "os"
+    "strings"
+)
+
+type CopyMode int
+
+const (
+    ModeCopy CopyMode = iota
+    ModeHardlink
+    ModeReflink
+    ModeSymlink
+)
+
+func ParseCopyMode(mode string) (CopyMode, error) {
+    switch strings.ToLower(mode) {
+    case "", "copy":
+        return ModeCopy, nil
+    case "hardlink":
+        return ModeHardlink, nil
+    case "reflink":
+        return ModeReflink, nil
+    case "symlink":
+        return ModeSymlink, nil
+    default:
+        return ModeCopy, fmt.Errorf("unknown copy mode %q (want copy|hardlink|reflink|symlink)", mode)
+    }
+}
+
+type CopyOptions struct {
+    Mode           CopyMode
+    Force          bool
+    NoClobber      bool
+    FollowSymlinks bool
+    PreservePerms  bool
+    Dedupe         *DedupeCoordinator
+    Journal        *Journal
+}
+
+// CopyFileWithMode dispatches to the requested CopyMode, falling back to a
+// plain byte copy whenever the fast path can't be used (cross-device links,
+// filesystems without reflink support, and so on).
+func CopyFileWithMode(from string, to string, opts CopyOptions) (int64, error) {
+    n, _, err := copyFileWithModeCore(from, to, opts, nil)
+    return n, err
+}
+
+// CopyFileWithModeHashing behaves exactly like CopyFileWithMode, except that
+// when the copy actually reads the source's bytes (the ModeCopy path, or a
+// post-hoc read of the materialized destination for ModeHardlink/ModeReflink,
+// which otherwise never stream the content) it feeds them through hasher as
+// it goes, so the caller gets a content digest without a second read of the
+// file. hashed reports whether hasher actually saw the file's bytes: a
+// symlink (recreated rather than copied) has no content to hash, so callers
+// must check hashed before trusting hasher's sum.
+func CopyFileWithModeHashing(from string, to string, opts CopyOptions, hasher hash.Hash) (int64, bool, error) {
+    return copyFileWithModeCore(from, to, opts, hasher)
+}
+
+func copyFileWithModeCore(from string, to string, opts CopyOptions, hasher hash.Hash) (int64, bool, error) {
+    if destExists(to) {
+        if opts.NoClobber {
+            return 0, false, fmt.Errorf("%s already exists, skipping (-NoClobber)", to)
+        }
+        if opts.Force {
+            if err := os.Remove(to); err != nil {
+                return 0, false, fmt.Errorf("could not remove existing %s: %v", to, err)
+            }
+        }
+    }
+
+    sourceInfo, err := os.Lstat(from)
+    if err != nil {
+        return 0, false, err
+    }
+
+    if sourceInfo.Mode()&os.ModeSymlink != 0 && !opts.FollowSymlinks {
+        return 0, false, recreateSymlink(from, to)
+    }
+
+    sourceStat, err := os.Stat(from)
+    if err != nil {
+        return 0, false, err
+    }
+    if !sourceStat.Mode().IsRegular() {
+        return 0, false, fmt.Errorf("%s is not a regular file", from)
+    }
+
+    switch opts.Mode {
+    case ModeHardlink:
+        if err := os.Link(from, to); err == nil {
+            n := sourceStat.Size()
+            if hasher == nil {
+                return n, false, nil
+            }
+            if err := hashExistingFile(to, hasher); err != nil {
+                return n, false, err
+            }
+            return n, true, nil
+        } else {
+            log.Printf("hardlink %s -> %s failed (%v), falling back to byte copy", from, to, err)
+        }
+    case ModeReflink:
+        if n, err := tryReflink(from, to, sourceStat); err == nil {
+            if opts.PreservePerms {
+                if err := preserveMetadata(to, sourceStat); err != nil {
+                    return n, false, err
+                }
+            }
+            if hasher == nil {
+                return n, false, nil
+            }
+            if err := hashExistingFile(to, hasher); err != nil {
+                return n, false, err
+            }
+            return n, true, nil
+        } else {
+            log.Printf("reflink %s -> %s failed (%v), falling back to byte copy", from, to, err)
+        }
+    case ModeSymlink:
+        return sourceStat.Size(), false, recreateSymlink(from, to)
+    }
+
+    nBytes, err := copyBytes(from, to, hasher)
+    if err != nil {
+        return nBytes, false, err
+    }
+
+    if opts.PreservePerms {
+        if err := preserveMetadata(to, sourceStat); err != nil {
+            return nBytes, hasher != nil, err
+        }
+    }
+
+    return nBytes, hasher != nil, nil
+}
+
+// copyBytes copies from to to, optionally tee-ing the bytes through hasher
+// as they're read so callers that need a content digest (dedupe, the
+// journal) don't have to read the file a second time afterward.
+func copyBytes(from string, to string, hasher hash.Hash) (int64, error) {
+    source, err := os.Open(from)
+    if err != nil {
+        return 0, err
+    }
+    defer source.Close()
+
+    destination, err := os.Create(to)
+    if err != nil {
+        return 0, err
+    }
+    defer destination.Close()
+
+    var reader io.Reader = source
+    if hasher != nil {
+        reader = io.TeeReader(source, hasher)
+    }
+    return io.Copy(destination, reader)
+}
+
+// hashExistingFile feeds path's contents through hasher. It's used after
+// ModeHardlink/ModeReflink, which materialize the destination without ever
+// streaming the source's bytes through this process, so this is the first
+// read of the content rather than a second one.
+func hashExistingFile(path string, hasher hash.Hash) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    _, err = io.Copy(hasher, file)
+    return err
+}
+
+func recreateSymlink(from string, to string) error {
+    target, err := os.Readlink(from)
+    if err != nil {
+        return err
+    }
+    if err := os.RemoveAll(to); err != nil {
+        return err
+    }
+    return os.Symlink(target, to)
+}
+
+func preserveMetadata(path string, info os.FileInfo) error {
+    if err := os.Chmod(path, info.Mode()); err != nil {
+        return err
+    }
+    return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+func destExists(path string) bool {
+    _, err := os.Lstat(path)
+    return err == nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "hash"
+    "log"
+    "os"
+    "strings"
+    "sync"
+
+    "lukechampine.com/blake3"
+)
+
+type HashAlgo string
+
+const (
+    HashSHA256 HashAlgo = "sha256"
+    HashSHA512 HashAlgo = "sha512"
+    HashBLAKE3 HashAlgo = "blake3"
+)
+
+func ParseHashAlgo(algo string) (HashAlgo, error) {
+    switch strings.ToLower(algo) {
+    case "", "sha256":
+        return HashSHA256, nil
+    case "sha512":
+        return HashSHA512, nil
+    case "blake3":
+        return HashBLAKE3, nil
+    default:
+        return HashSHA256, fmt.Errorf("unknown hash algorithm %q (want sha256|sha512|blake3)", algo)
+    }
+}
+
+func newHasher(algo HashAlgo) (hash.Hash, error) {
+    switch algo {
+    case HashSHA256:
+        return sha256.New(), nil
+    case HashSHA512:
+        return sha512.New(), nil
+    case HashBLAKE3:
+        return blake3.New(32, nil), nil
+    default:
+        return nil, fmt.Errorf("unknown hash algorithm %q", algo)
+    }
+}
+
+// dedupeState tracks the first destination a given content hash was copied
+// to, plus every source path that hashed to it. once guards the decision of
+// which caller gets to be "the first instance" so two goroutines that finish
+// hashing identical files at the same moment can't both win.
+type dedupeState struct {
+    once          sync.Once
+    mu            sync.Mutex
+    destPath      string
+    size          int64
+    originalPaths []string
+}
+
+type DedupeCoordinator struct {
+    Algo   HashAlgo
+    Action string // "hardlink" or "skip"
+    table  sync.Map
+}
+
+func NewDedupeCoordinator(algo HashAlgo, action string) *DedupeCoordinator {
+    return &DedupeCoordinator{Algo: algo, Action: action}
+}
+
+// CopyWithDedupe materializes job.Destination via CopyFileWithModeHashing, so
+// it honors the same CopyOptions (Force, NoClobber, FollowSymlinks,
+// PreservePerms, Mode) as a non-deduped copy, while still hashing the
+// content in the same pass wherever that copy actually streams bytes. It
+// then reconciles the result against any other file that has already hashed
+// to the same digest: the first copy is kept in place, later ones are
+// replaced with a hardlink (or removed, for Action == "skip").
+//
+// It returns the hex digest it computed, or "" if job.Destination was
+// removed rather than kept (Action == "skip" on a duplicate) — callers must
+// not treat job.Destination as existing in that case.
+func (d *DedupeCoordinator) CopyWithDedupe(job CopyJob, opts CopyOptions) (int64, string, error) {
+    hasher, err := newHasher(d.Algo)
+    if err != nil {
+        return 0, "", err
+    }
+
+    n, hashed, err := CopyFileWithModeHashing(job.Source, job.Destination, opts, hasher)
+    if err != nil {
+        return n, "", err
+    }
+    if !hashed {
+        // e.g. the source was a symlink left un-followed: there's no
+        // content to dedupe against, so just report what happened.
+        return n, "", nil
+    }
+
+    digest := hex.EncodeToString(hasher.Sum(nil))
+
+    stateIface, _ := d.table.LoadOrStore(digest, &dedupeState{})
+    state := stateIface.(*dedupeState)
+
+    isFirst := false
+    state.once.Do(func() {
+        isFirst = true
+        state.destPath = job.Destination
+        state.size = n
+    })
+
+    state.mu.Lock()
+    state.originalPaths = append(state.originalPaths, job.Source)
+    state.mu.Unlock()
+
+    if isFirst {
+        return n, digest, nil
+    }
+
+    if err := os.Remove(job.Destination); err != nil {
+        return n, digest, err
+    }
+
+    if d.Action == "skip" {
+        log.Printf("skipping %s, duplicate of %s", job.Source, state.destPath)
+        return n, "", nil
+    }
+
+    if err := os.Link(state.destPath, job.Destination); err != nil {
+        log.Printf("hardlink %s -> %s failed (%v), falling back to byte copy", state.destPath, job.Destination, err)
+        n, err := copyBytes(job.Source, job.Destination, nil)
+        return n, digest, err
+    }
+    return n, digest, nil
+}
+
+type ManifestEntry struct {
+    SHA256        string   `json:"sha256"`
+    Size          int64    `json:"size"`
+    OriginalPaths []string `json:"originalPaths"`
+    DestPath      string   `json:"destPath"`
+}
+
+// WriteManifest emits a JSON array describing every distinct content hash
+// seen during the run, so the copy set can be reproduced or audited later.
+func (d *DedupeCoordinator) WriteManifest(path string) error {
+    entries := make([]ManifestEntry, 0)
+    d.table.Range(func(key, value interface{}) bool {
+        state := value.(*dedupeState)
+        entries = append(entries, ManifestEntry{
+            SHA256:        key.(string),
+            Size:          state.size,
+            OriginalPaths: state.originalPaths,
+            DestPath:      state.destPath,
+        })
+        return true
+    })
+
+    data, err := json.MarshalIndent(entries, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "sync"
+    "syscall"
+    "testing"
+)
+
+func writeIdenticalSources(t *testing.T, dir string, n int, content []byte) []string {
+    t.Helper()
+    paths := make([]string, n)
+    for i := 0; i < n; i++ {
+        path := filepath.Join(dir, "src", filepathIndex(i))
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatalf("mkdir: %v", err)
+        }
+        if err := os.WriteFile(path, content, 0644); err != nil {
+            t.Fatalf("write source %d: %v", i, err)
+        }
+        paths[i] = path
+    }
+    return paths
+}
+
+func filepathIndex(i int) string {
+    return "dup" + string(rune('a'+i)) + ".bin"
+}
+
+// TestCopyWithDedupeConcurrentDuplicates copies many identical files through
+// the same DedupeCoordinator concurrently, and checks that exactly one of
+// them survives as its own inode while the rest end up hardlinked to it —
+// the race the once/sync.Map pairing in dedupeState exists to prevent.
+func TestCopyWithDedupeConcurrentDuplicates(t *testing.T) {
+    tmp := t.TempDir()
+    const n = 16
+    content := []byte("identical payload for dedupe race test")
+    sources := writeIdenticalSources(t, tmp, n, content)
+
+    destDir := filepath.Join(tmp, "dest")
+    if err := os.MkdirAll(destDir, 0755); err != nil {
+        t.Fatalf("mkdir dest: %v", err)
+    }
+
+    coordinator := NewDedupeCoordinator(HashSHA256, "hardlink")
+    opts := CopyOptions{Mode: ModeCopy, Dedupe: coordinator}
+
+    var wg sync.WaitGroup
+    digests := make([]string, n)
+    for i, src := range sources {
+        i, src := i, src
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            job := CopyJob{UID: int64(i), Source: src, Destination: filepath.Join(destDir, filepathIndex(i))}
+            _, digest, err := coordinator.CopyWithDedupe(job, opts)
+            if err != nil {
+                t.Errorf("CopyWithDedupe(%d): %v", i, err)
+                return
+            }
+            digests[i] = digest
+        }()
+    }
+    wg.Wait()
+
+    for i, digest := range digests {
+        if digest == "" {
+            t.Fatalf("job %d: expected a digest, got none", i)
+        }
+    }
+
+    var inodes = make(map[uint64]bool)
+    for i := range sources {
+        dest := filepath.Join(destDir, filepathIndex(i))
+        info, err := os.Stat(dest)
+        if err != nil {
+            t.Fatalf("stat %s: %v", dest, err)
+        }
+        sys, ok := info.Sys().(*syscall.Stat_t)
+        if !ok {
+            t.Fatalf("unexpected Sys() type for %s", dest)
+        }
+        inodes[sys.Ino] = true
+    }
+    if len(inodes) != 1 {
+        t.Errorf("got %d distinct inodes across %d duplicate copies, want 1 (all hardlinked together)", len(inodes), n)
+    }
+}
+
+// TestCopyWithDedupeSkipRemovesDuplicates checks that Action == "skip"
+// leaves exactly one file on disk and reports no digest for every
+// duplicate it removes, so callers know there's nothing left to journal.
+func TestCopyWithDedupeSkipRemovesDuplicates(t *testing.T) {
+    tmp := t.TempDir()
+    const n = 8
+    content := []byte("identical payload for dedupe skip test")
+    sources := writeIdenticalSources(t, tmp, n, content)
+
+    destDir := filepath.Join(tmp, "dest")
+    if err := os.MkdirAll(destDir, 0755); err != nil {
+        t.Fatalf("mkdir dest: %v", err)
+    }
+
+    coordinator := NewDedupeCoordinator(HashSHA256, "skip")
+    opts := CopyOptions{Mode: ModeCopy, Dedupe: coordinator}
+
+    var wg sync.WaitGroup
+    survived := make([]bool, n)
+    for i, src := range sources {
+        i, src := i, src
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            job := CopyJob{UID: int64(i), Source: src, Destination: filepath.Join(destDir, filepathIndex(i))}
+            _, digest, err := coordinator.CopyWithDedupe(job, opts)
+            if err != nil {
+                t.Errorf("CopyWithDedupe(%d): %v", i, err)
+                return
+            }
+            survived[i] = digest != ""
+        }()
+    }
+    wg.Wait()
+
+    survivors := 0
+    for i, ok := range survived {
+        dest := filepath.Join(destDir, filepathIndex(i))
+        _, statErr := os.Stat(dest)
+        exists := statErr == nil
+        if ok != exists {
+            t.Errorf("job %d: digest reported non-empty=%v, but file exists=%v", i, ok, exists)
+        }
+        if ok {
+            survivors++
+        }
+    }
+    if survivors != 1 {
+        t.Errorf("got %d surviving files, want exactly 1", survivors)
+    }
+}
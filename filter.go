@@ -0,0 +1,183 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/bmatcuk/doublestar/v4"
+)
+
+// Predicate reports whether a discovered file should be kept.
+type Predicate func(FileMetadata) bool
+
+func andAll(preds []Predicate) Predicate {
+    return func(d FileMetadata) bool {
+        for _, p := range preds {
+            if !p(d) {
+                return false
+            }
+        }
+        return true
+    }
+}
+
+// stringSliceFlag lets a flag (e.g. -ExcludeGlob) be passed more than once,
+// accumulating each value.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+    return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(v string) error {
+    *s = append(*s, v)
+    return nil
+}
+
+// FilterConfig mirrors the filter-related command line flags.
+type FilterConfig struct {
+    Ext            string
+    MinSize        string
+    MaxSize        string
+    ModifiedAfter  string
+    ModifiedBefore string
+    NameGlob       string
+    NameRegex      string
+    ExcludeGlobs   []string
+    Where          string
+}
+
+// BuildPredicate combines every configured filter into a single predicate,
+// ANDing them together. An empty FilterConfig matches everything.
+func BuildPredicate(cfg FilterConfig) (Predicate, error) {
+    preds := make([]Predicate, 0)
+
+    if cfg.Ext != "" {
+        targets := ParseFileTypes(cfg.Ext, ",")
+        preds = append(preds, func(d FileMetadata) bool {
+            _, ok := targets[strings.ToLower(d.Ext)]
+            return ok
+        })
+    }
+
+    if cfg.MinSize != "" {
+        n, err := parseSize(cfg.MinSize)
+        if err != nil {
+            return nil, fmt.Errorf("-MinSize: %v", err)
+        }
+        preds = append(preds, func(d FileMetadata) bool { return d.Size >= n })
+    }
+
+    if cfg.MaxSize != "" {
+        n, err := parseSize(cfg.MaxSize)
+        if err != nil {
+            return nil, fmt.Errorf("-MaxSize: %v", err)
+        }
+        preds = append(preds, func(d FileMetadata) bool { return d.Size <= n })
+    }
+
+    if cfg.ModifiedAfter != "" {
+        t, err := parseTimeArg(cfg.ModifiedAfter)
+        if err != nil {
+            return nil, fmt.Errorf("-ModifiedAfter: %v", err)
+        }
+        preds = append(preds, func(d FileMetadata) bool { return d.ModDate.After(t) })
+    }
+
+    if cfg.ModifiedBefore != "" {
+        t, err := parseTimeArg(cfg.ModifiedBefore)
+        if err != nil {
+            return nil, fmt.Errorf("-ModifiedBefore: %v", err)
+        }
+        preds = append(preds, func(d FileMetadata) bool { return d.ModDate.Before(t) })
+    }
+
+    if cfg.NameGlob != "" {
+        glob := cfg.NameGlob
+        preds = append(preds, func(d FileMetadata) bool {
+            matched, _ := doublestar.Match(glob, d.Path)
+            return matched
+        })
+    }
+
+    if cfg.NameRegex != "" {
+        re, err := regexp.Compile(cfg.NameRegex)
+        if err != nil {
+            return nil, fmt.Errorf("-NameRegex: %v", err)
+        }
+        preds = append(preds, func(d FileMetadata) bool { return re.MatchString(d.Name) })
+    }
+
+    for _, exclude := range cfg.ExcludeGlobs {
+        exclude := exclude
+        preds = append(preds, func(d FileMetadata) bool {
+            matched, _ := doublestar.Match(exclude, d.Path)
+            return !matched
+        })
+    }
+
+    if cfg.Where != "" {
+        wherePred, err := ParseWhere(cfg.Where)
+        if err != nil {
+            return nil, fmt.Errorf("-Where: %v", err)
+        }
+        preds = append(preds, wherePred)
+    }
+
+    return andAll(preds), nil
+}
+
+// parseSize accepts a plain byte count or a count with a binary (KiB, MiB,
+// GiB, TiB) or decimal (KB, MB, GB, TB) suffix, e.g. "10MiB" or "2GB".
+func parseSize(s string) (int64, error) {
+    s = strings.TrimSpace(s)
+    units := []struct {
+        suffix string
+        mult   float64
+    }{
+        {"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+        {"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+        {"B", 1},
+    }
+    for _, u := range units {
+        if strings.HasSuffix(s, u.suffix) {
+            n, err := strconv.ParseFloat(strings.TrimSuffix(s, u.suffix), 64)
+            if err != nil {
+                return 0, fmt.Errorf("invalid size %q", s)
+            }
+            return int64(n * u.mult), nil
+        }
+    }
+    n, err := strconv.ParseInt(s, 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("invalid size %q", s)
+    }
+    return n, nil
+}
+
+// parseTimeArg accepts an RFC3339 timestamp, a bare "YYYY-MM-DD" date, or a
+// duration relative to now, either a Go duration ("2h") or a day count
+// ("7d").
+func parseTimeArg(s string) (time.Time, error) {
+    s = strings.TrimSpace(s)
+
+    if t, err := time.Parse(time.RFC3339, s); err == nil {
+        return t, nil
+    }
+    if t, err := time.Parse("2006-01-02", s); err == nil {
+        return t, nil
+    }
+    if strings.HasSuffix(s, "d") {
+        if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+            return time.Now().AddDate(0, 0, -days), nil
+        }
+    }
+    if d, err := time.ParseDuration(s); err == nil {
+        return time.Now().Add(-d), nil
+    }
+
+    return time.Time{}, fmt.Errorf("invalid time %q (want RFC3339, YYYY-MM-DD, or a relative duration like 7d/2h)", s)
+}
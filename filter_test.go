@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestBuildPredicateWithoutExtMatchesAnyExtension(t *testing.T) {
+    files := []FileMetadata{
+        {Name: "main.go", Ext: ".go", Path: "/src/main.go", Size: 100},
+        {Name: "README.md", Ext: ".md", Path: "/src/README.md", Size: 100},
+    }
+
+    pred, err := BuildPredicate(FilterConfig{NameGlob: "**/*.go"})
+    if err != nil {
+        t.Fatalf("BuildPredicate returned error: %v", err)
+    }
+
+    if !pred(files[0]) {
+        t.Errorf("expected %q to match -NameGlob without -Ext set", files[0].Path)
+    }
+    if pred(files[1]) {
+        t.Errorf("expected %q not to match -NameGlob **/*.go", files[1].Path)
+    }
+}
+
+func TestBuildPredicateStillAppliesExtWhenSet(t *testing.T) {
+    files := []FileMetadata{
+        {Name: "main.go", Ext: ".go", Path: "/src/main.go", Size: 100},
+        {Name: "main.py", Ext: ".py", Path: "/src/main.py", Size: 100},
+    }
+
+    pred, err := BuildPredicate(FilterConfig{Ext: ".go"})
+    if err != nil {
+        t.Fatalf("BuildPredicate returned error: %v", err)
+    }
+
+    if !pred(files[0]) {
+        t.Errorf("expected %q to match -Ext=.go", files[0].Path)
+    }
+    if pred(files[1]) {
+        t.Errorf("expected %q not to match -Ext=.go", files[1].Path)
+    }
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+    "encoding/json"
+    "os"
+    "sync"
+    "time"
+)
+
+// JournalEntry is one append-only record of a completed copy, written as a
+// single line of JSON so the state file stays grep-able and crash-safe.
+// SHA256 holds the digest computed while the file was copied; if -Dedupe was
+// active for the run it's actually a digest under -HashAlgo (sha256 by
+// default), reused rather than recomputed to avoid reading the file twice.
+type JournalEntry struct {
+    UID         int64     `json:"uid"`
+    SourcePath  string    `json:"sourcePath"`
+    DestPath    string    `json:"destPath"`
+    SHA256      string    `json:"sha256"`
+    Size        int64     `json:"size"`
+    CompletedAt time.Time `json:"completedAt"`
+}
+
+// Journal appends JournalEntry records to a state file, fsyncing after each
+// write so a crash mid-run never loses a record that was reported complete.
+type Journal struct {
+    mu   sync.Mutex
+    file *os.File
+    enc  *json.Encoder
+}
+
+func OpenJournal(path string) (*Journal, error) {
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+    if err != nil {
+        return nil, err
+    }
+    return &Journal{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// RecordCopy appends an entry for a completed copy. digest is the content
+// hash computed while the file was copied (by CopyFileWithModeHashing or the
+// dedupe coordinator, whichever ran) — RecordCopy never re-reads the
+// destination itself, since for a NAS-mounted -ToDir that would mean paying
+// for every file's network I/O twice.
+func (j *Journal) RecordCopy(job CopyJob, size int64, digest string) error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    if err := j.enc.Encode(JournalEntry{
+        UID:         job.UID,
+        SourcePath:  job.Source,
+        DestPath:    job.Destination,
+        SHA256:      digest,
+        Size:        size,
+        CompletedAt: time.Now(),
+    }); err != nil {
+        return err
+    }
+    return j.file.Sync()
+}
+
+func (j *Journal) Close() error {
+    if j == nil {
+        return nil
+    }
+    return j.file.Close()
+}
+
+// LoadJournal reads an existing state file into a map keyed by source path,
+// so a resumed run can look up what's already been copied. A later entry
+// for the same source path overwrites an earlier one, since the journal is
+// append-only and a source can legitimately be recopied after a failure.
+func LoadJournal(path string) (map[string]JournalEntry, error) {
+    entries := make(map[string]JournalEntry)
+
+    file, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return entries, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    dec := json.NewDecoder(file)
+    for dec.More() {
+        var entry JournalEntry
+        if err := dec.Decode(&entry); err != nil {
+            return nil, err
+        }
+        entries[entry.SourcePath] = entry
+    }
+    return entries, nil
+}
+
+// CompactJournal rewrites the state file so it contains exactly one,
+// de-duplicated entry per source path, dropping the superseded entries that
+// accumulate across retried runs.
+func CompactJournal(path string) error {
+    entries, err := LoadJournal(path)
+    if err != nil {
+        return err
+    }
+
+    tmpPath := path + ".compact"
+    file, err := os.Create(tmpPath)
+    if err != nil {
+        return err
+    }
+
+    enc := json.NewEncoder(file)
+    for _, entry := range entries {
+        if err := enc.Encode(entry); err != nil {
+            file.Close()
+            return err
+        }
+    }
+    if err := file.Sync(); err != nil {
+        file.Close()
+        return err
+    }
+    if err := file.Close(); err != nil {
+        return err
+    }
+
+    return os.Rename(tmpPath, path)
+}
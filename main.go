@@ -1,13 +1,14 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
 	"sync"
 	"time"
@@ -25,6 +26,7 @@ type FileMetadata struct {
 }
 
 type CopyJob struct {
+    UID int64
     Source string
     Destination string
 }
@@ -37,32 +39,6 @@ func GetHomeDir() (string, error) {
     return homeDir, nil
 }
 
-func CopyFile(from string, to string) (int64, error) {
-    sourceFileStat, err := os.Stat(from)
-    if err != nil {
-        return 0, err
-    }
-
-    if !sourceFileStat.Mode().IsRegular() {
-        return 0, fmt.Errorf("%s is not a regular file", from)
-    }
-
-    source, err := os.Open(from)
-    if err != nil {
-        return 0, err
-    }
-    defer source.Close()
-
-    destination, err := os.Create(to)
-    if err != nil {
-        return 0, err
-    }
-    defer destination.Close()
-
-    nBytes, err := io.Copy(destination, source)
-    return nBytes, err
-}
-
 func ParseFileTypes(fileTypes string, sep string) map[string]bool {
     SplitFileTypeString := strings.Split(fileTypes, sep)
     TargetFileTypes := make(map[string]bool)
@@ -72,10 +48,31 @@ func ParseFileTypes(fileTypes string, sep string) map[string]bool {
     return TargetFileTypes
 }
 
-func CopyFileWorker(jobs <- chan CopyJob, errors chan <- error, wg *sync.WaitGroup) {
+func CopyFileWorker(jobs <- chan CopyJob, errors chan <- error, wg *sync.WaitGroup, opts CopyOptions) {
     for job := range jobs {
         log.Println("Copying ", job.Source, " to ", job.Destination)
-        _, err := CopyFile(job.Source, job.Destination)
+        var n int64
+        var digest string
+        var err error
+        switch {
+        case opts.Dedupe != nil:
+            n, digest, err = opts.Dedupe.CopyWithDedupe(job, opts)
+        case opts.Journal != nil:
+            hasher := sha256.New()
+            var hashed bool
+            n, hashed, err = CopyFileWithModeHashing(job.Source, job.Destination, opts, hasher)
+            if hashed {
+                digest = hex.EncodeToString(hasher.Sum(nil))
+            }
+        default:
+            n, err = CopyFileWithMode(job.Source, job.Destination, opts)
+        }
+        // digest is "" when there's nothing left at job.Destination to
+        // record (a skipped duplicate) or nothing meaningful to hash (a
+        // recreated symlink), so there's no journal entry to write.
+        if err == nil && opts.Journal != nil && digest != "" {
+            err = opts.Journal.RecordCopy(job, n, digest)
+        }
         if err != nil {
             errors <- err
         }
@@ -96,135 +93,258 @@ func main() {
 
     var RootDir string
     flag.StringVar(&RootDir, "RootDir", homeDir, "The root directory to crawl")
-    var FileTypes string
-    flag.StringVar(&FileTypes, "FileType", ".py", "The file types to find")
+    var Ext string
+    flag.StringVar(&Ext, "Ext", "", "Comma-separated file extensions to match (empty matches any extension)")
+    var MinSize string
+    flag.StringVar(&MinSize, "MinSize", "", "Only match files at least this size, e.g. 10MiB, 2GB")
+    var MaxSize string
+    flag.StringVar(&MaxSize, "MaxSize", "", "Only match files at most this size, e.g. 10MiB, 2GB")
+    var ModifiedAfter string
+    flag.StringVar(&ModifiedAfter, "ModifiedAfter", "", "Only match files modified after this time: RFC3339, YYYY-MM-DD, or relative like 7d")
+    var ModifiedBefore string
+    flag.StringVar(&ModifiedBefore, "ModifiedBefore", "", "Only match files modified before this time: RFC3339, YYYY-MM-DD, or relative like 7d")
+    var NameGlob string
+    flag.StringVar(&NameGlob, "NameGlob", "", "Only match paths satisfying this doublestar glob, e.g. **/*.py")
+    var NameRegex string
+    flag.StringVar(&NameRegex, "NameRegex", "", "Only match file names satisfying this regexp")
+    var ExcludeGlobs stringSliceFlag
+    flag.Var(&ExcludeGlobs, "ExcludeGlob", "Exclude paths matching this doublestar glob (repeatable)")
+    var Where string
+    flag.StringVar(&Where, "Where", "", "Filter expression, e.g. `ext in (.py,.go) and size > 1MB and modified_after 2024-01-01`")
     var ToDir string
     flag.StringVar(&ToDir, "ToDir", "/tmp/", "The directory to copy files into")
     var CopyFilesFlag bool
     flag.BoolVar(&CopyFilesFlag, "CopyFilesFlag", false, "Copy files into ToDir directory")
-    var EchoFilesFlag bool
-    flag.BoolVar(&EchoFilesFlag, "EchoFilesFlag", true, "Print results to stdout")
-    var ToCSV bool
-    flag.BoolVar(&ToCSV, "ToCSV", false, "Output to CSV file?")
+    var Output string
+    flag.StringVar(&Output, "Output", "stdout", "Where discovered records are written: csv|jsonl|sqlite|stdout|none")
+    var OutputPath string
+    flag.StringVar(&OutputPath, "OutputPath", "", "Path for the -Output sink (ignored for stdout/none; defaults to ./output.<ext>)")
     var NumWorkers int64
     flag.Int64Var(&NumWorkers, "NumWorkers", 4, "Number of jobs to parallelise")
+    var Mode string
+    flag.StringVar(&Mode, "Mode", "copy", "Copy mode: copy|hardlink|reflink|symlink")
+    var Force bool
+    flag.BoolVar(&Force, "Force", false, "Overwrite an existing destination file")
+    var NoClobber bool
+    flag.BoolVar(&NoClobber, "NoClobber", false, "Never overwrite an existing destination file")
+    var FollowSymlinks bool
+    flag.BoolVar(&FollowSymlinks, "FollowSymlinks", true, "Dereference symlinks instead of recreating them")
+    var PreservePerms bool
+    flag.BoolVar(&PreservePerms, "PreservePerms", false, "Preserve file mode and modification time on the destination")
+    var MaxInFlight int
+    flag.IntVar(&MaxInFlight, "MaxInFlight", 1024, "Maximum number of discovered files buffered ahead of the workers")
+    var Dedupe bool
+    flag.BoolVar(&Dedupe, "Dedupe", false, "Content-addressed dedupe: hardlink (or skip) copies that match an already-copied file's hash")
+    var DedupeAction string
+    flag.StringVar(&DedupeAction, "DedupeAction", "hardlink", "What to do with a duplicate once found: hardlink|skip")
+    var HashAlgoFlag string
+    flag.StringVar(&HashAlgoFlag, "HashAlgo", "sha256", "Hash algorithm used for -Dedupe: sha256|sha512|blake3")
+    var ManifestPath string
+    flag.StringVar(&ManifestPath, "ManifestPath", "", "Write a JSON manifest of {sha256, size, originalPaths, destPath} here after a -Dedupe run")
+    var StateFile string
+    flag.StringVar(&StateFile, "StateFile", "", "Append-only JSON Lines journal of completed copies, for resumable runs")
+    var Resume bool
+    flag.BoolVar(&Resume, "Resume", false, "Skip sources already recorded as complete in -StateFile")
+    var Compact bool
+    flag.BoolVar(&Compact, "Compact", false, "Rewrite -StateFile to one entry per source path, then exit")
     flag.Parse()
 
-    log.Printf("crawler called\n")
-    log.Printf("Parsing %v\n", RootDir)
-    log.Printf("Looking for files of type: %v\n", FileTypes)
-    log.Printf("Output directory: %v\n", ToDir)
-    log.Printf("Copy files? %v\n", CopyFilesFlag)
-    log.Printf("Echo files? %v\n", EchoFilesFlag)
-    log.Printf("Output to CSV file? %v\n", ToCSV)
-    log.Printf("Number of workers: %v\n", NumWorkers)
-
-    ParsedFileTypes := ParseFileTypes(FileTypes, ",")
+    if Compact {
+        if StateFile == "" {
+            fmt.Println("Error: -Compact requires -StateFile")
+            os.Exit(1)
+        }
+        if err := CompactJournal(StateFile); err != nil {
+            fmt.Println("Error compacting state file: ", err)
+            os.Exit(1)
+        }
+        fmt.Println("Compacted state file: ", StateFile)
+        return
+    }
 
-    if EchoFilesFlag {
-        fmt.Printf("UID\tName\tExtension\tModDate\tIsDir\tSize(B)\tFilePath\tIsRegularfile\n")
+    ParsedCopyMode, copyModeErr := ParseCopyMode(Mode)
+    if copyModeErr != nil {
+        fmt.Println("Error: ", copyModeErr)
+        os.Exit(1)
+    }
+    sink, sinkErr := NewOutputSink(Output, OutputPath)
+    if sinkErr != nil {
+        fmt.Println("Error: ", sinkErr)
+        os.Exit(1)
+    }
+    matches, filterErr := BuildPredicate(FilterConfig{
+        Ext:            Ext,
+        MinSize:        MinSize,
+        MaxSize:        MaxSize,
+        ModifiedAfter:  ModifiedAfter,
+        ModifiedBefore: ModifiedBefore,
+        NameGlob:       NameGlob,
+        NameRegex:      NameRegex,
+        ExcludeGlobs:   ExcludeGlobs,
+        Where:          Where,
+    })
+    if filterErr != nil {
+        fmt.Println("Error: ", filterErr)
+        os.Exit(1)
     }
 
-    CopyJobs := make([]CopyJob, 0)
-    Count := 0
-    AllMetadata := make([]FileMetadata, 0)
-    err := filepath.Walk(RootDir, func(path string, info os.FileInfo, err error) error {
+    if Resume && StateFile != "" {
+        completed, err := LoadJournal(StateFile)
         if err != nil {
-            return err
+            fmt.Println("Error loading state file: ", err)
+            os.Exit(1)
         }
+        baseMatches := matches
+        matches = func(d FileMetadata) bool {
+            if !baseMatches(d) {
+                return false
+            }
+            entry, ok := completed[d.Path]
+            if !ok {
+                return true
+            }
+            if info, err := os.Stat(entry.DestPath); err != nil || info.Size() != entry.Size {
+                log.Printf("partial destination for %s, recopying\n", d.Path)
+                os.Remove(entry.DestPath)
+                return true
+            }
+            return false
+        }
+    }
 
-        data := FileMetadata{
-            uid: int64(Count),
-            Name: info.Name(),
-            Ext: filepath.Ext(path),
-            ModDate: info.ModTime(),
-            IsDir: info.IsDir(),
-            Size: info.Size(),
-            Path: path,
-            IsReg: info.Mode().IsRegular(),
+    var journal *Journal
+    if StateFile != "" {
+        var journalErr error
+        journal, journalErr = OpenJournal(StateFile)
+        if journalErr != nil {
+            fmt.Println("Error opening state file: ", journalErr)
+            os.Exit(1)
         }
+        defer journal.Close()
+    }
 
+    var dedupeCoordinator *DedupeCoordinator
+    if Dedupe {
+        hashAlgo, hashAlgoErr := ParseHashAlgo(HashAlgoFlag)
+        if hashAlgoErr != nil {
+            fmt.Println("Error: ", hashAlgoErr)
+            os.Exit(1)
+        }
+        dedupeCoordinator = NewDedupeCoordinator(hashAlgo, DedupeAction)
+    }
 
+    CopyOpts := CopyOptions{
+        Mode:           ParsedCopyMode,
+        Force:          Force,
+        NoClobber:      NoClobber,
+        FollowSymlinks: FollowSymlinks,
+        PreservePerms:  PreservePerms,
+        Dedupe:         dedupeCoordinator,
+        Journal:        journal,
+    }
 
-        _, IsOfTargetFileType := ParsedFileTypes[strings.ToLower(data.Ext)]
-        if !data.IsDir && IsOfTargetFileType {
-            AllMetadata = append(AllMetadata, data)
-            if EchoFilesFlag {
-                fmt.Printf("%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
-                    data.uid,
-                    data.Name, 
-                    data.Ext, 
-                    data.ModDate,
-                    data.IsDir,
-                    data.Size,
-                    data.Path,
-                    data.IsReg,
-                )
-            }
+    log.Printf("crawler called\n")
+    log.Printf("Parsing %v\n", RootDir)
+    log.Printf("Looking for files of type: %v\n", Ext)
+    log.Printf("Output directory: %v\n", ToDir)
+    log.Printf("Copy files? %v\n", CopyFilesFlag)
+    log.Printf("Output sink: %v (%v)\n", Output, OutputPath)
+    log.Printf("Number of workers: %v\n", NumWorkers)
+    log.Printf("Max in-flight files: %v\n", MaxInFlight)
+    log.Printf("Dedupe? %v (algo=%v action=%v)\n", Dedupe, HashAlgoFlag, DedupeAction)
+    if Where != "" {
+        log.Printf("Where: %v\n", Where)
+    }
+    if StateFile != "" {
+        log.Printf("State file: %v (resume=%v)\n", StateFile, Resume)
+    }
 
-            if CopyFilesFlag {
-                job := CopyJob{
-                    data.Path,
-                    filepath.Join(ToDir, fmt.Sprint(data.uid) + "_" + data.Name),
-                }
-                CopyJobs = append(CopyJobs, job)
-            }
-            Count += 1
-        }
+    if err := sink.WriteHeader(); err != nil {
+        fmt.Println("Error writing sink header: ", err)
+        os.Exit(1)
+    }
 
-        return nil
-    })
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+
+    metadataChan := make(chan FileMetadata, MaxInFlight)
+    copyChan := make(chan FileMetadata, MaxInFlight)
+    sinkChan := make(chan FileMetadata, MaxInFlight)
+
+    var walkErr error
+    walkDone := make(chan struct{})
+    go func() {
+        defer close(walkDone)
+        walkErr = walkAndStream(ctx, RootDir, matches, metadataChan)
+    }()
+
+    go fanOut(metadataChan, copyChan, sinkChan)
 
-    jobs := make(chan CopyJob, len(CopyJobs))
-    jobErrors := make(chan error, len(CopyJobs))
+    jobs := make(chan CopyJob, MaxInFlight)
+    jobErrors := make(chan error, MaxInFlight)
     var wg sync.WaitGroup
 
     log.Printf("Initalising %d workers\n", NumWorkers)
     for i := 0; i < int(NumWorkers); i++ {
-        go CopyFileWorker(jobs, jobErrors, &wg)
+        go CopyFileWorker(jobs, jobErrors, &wg, CopyOpts)
     }
 
-    for _, job := range CopyJobs {
-        jobs <- job
-        wg.Add(1)
-    }
-    close(jobs)
+    dispatchDone := make(chan struct{})
+    go func() {
+        defer close(dispatchDone)
+        dispatchCopyJobs(copyChan, jobs, ToDir, CopyFilesFlag, &wg)
+    }()
 
+    var collectedErrors []error
+    var errorsMu sync.Mutex
+    errorsDone := make(chan struct{})
     go func() {
+        defer close(errorsDone)
         for err := range jobErrors {
             log.Println(err)
+            errorsMu.Lock()
+            collectedErrors = append(collectedErrors, err)
+            errorsMu.Unlock()
+        }
+    }()
+
+    sinkDone := make(chan struct{})
+    go func() {
+        defer close(sinkDone)
+        for record := range sinkChan {
+            if err := sink.WriteRecord(record); err != nil {
+                log.Println("Error writing record to sink: ", err)
+            }
         }
     }()
 
+    <-dispatchDone
     wg.Wait()
     close(jobErrors)
 
+    <-walkDone
+    <-sinkDone
+    <-errorsDone
 
-    if ToCSV {
-        csvFile, err := os.Create("./output.csv")
-        if err != nil {
-            log.Println("Could not create CSV file: ", err)
-        }
-        defer csvFile.Close()
-
-        writer := csv.NewWriter(csvFile)
-        defer writer.Flush()
-        writer.Write([]string{"UID", "Name", "Extension", "ModDate", "IsDir", "Size(B)", "FilePath", "IsRegularFile"})
-        for _, record := range AllMetadata {
-            writer.Write([]string{
-                fmt.Sprintf("%d", record.uid),
-                record.Name,
-                record.Ext,
-                fmt.Sprintf("%v", record.ModDate),
-                fmt.Sprintf("%v", record.IsDir),
-                fmt.Sprintf("%v", record.Size),
-                record.Path,
-                fmt.Sprintf("%v", record.IsReg),
-            })
+    if err := sink.Close(); err != nil {
+        log.Println("Error closing output sink: ", err)
+        collectedErrors = append(collectedErrors, err)
+    }
+
+    if walkErr != nil {
+        log.Printf("Error walking the directory: %v\n", walkErr)
+        collectedErrors = append(collectedErrors, walkErr)
+    }
+
+    if dedupeCoordinator != nil && ManifestPath != "" {
+        if err := dedupeCoordinator.WriteManifest(ManifestPath); err != nil {
+            log.Printf("Error writing dedupe manifest: %v\n", err)
+            collectedErrors = append(collectedErrors, err)
         }
     }
 
-    if err != nil {
-        log.Printf("Error walking the directory: %v\n", err)
+    if len(collectedErrors) > 0 {
+        fmt.Printf("crawler finished with %d error(s), see crawler_log.txt\n", len(collectedErrors))
+        os.Exit(1)
     }
 }
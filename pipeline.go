@@ -0,0 +1,86 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+// walkAndStream walks rootDir and streams matching FileMetadata into out,
+// assigning each a monotonically increasing uid as it is discovered. It
+// closes out once the walk finishes (or ctx is cancelled) so downstream
+// consumers can range over the channel instead of waiting on a slice.
+func walkAndStream(ctx context.Context, rootDir string, matches Predicate, out chan<- FileMetadata) error {
+    defer close(out)
+
+    var count int64
+    return filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+        if ctxErr := ctx.Err(); ctxErr != nil {
+            return ctxErr
+        }
+        if err != nil {
+            return err
+        }
+
+        data := FileMetadata{
+            uid:     count,
+            Name:    info.Name(),
+            Ext:     filepath.Ext(path),
+            ModDate: info.ModTime(),
+            IsDir:   info.IsDir(),
+            Size:    info.Size(),
+            Path:    path,
+            IsReg:   info.Mode().IsRegular(),
+        }
+
+        if data.IsDir || !matches(data) {
+            return nil
+        }
+        count++
+
+        select {
+        case out <- data:
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+        return nil
+    })
+}
+
+// fanOut tees metadata from in to each of the given output channels so the
+// copy, CSV, and echo stages can all consume the same stream independently.
+// It closes every output channel once in is drained.
+func fanOut(in <-chan FileMetadata, outs ...chan<- FileMetadata) {
+    defer func() {
+        for _, out := range outs {
+            close(out)
+        }
+    }()
+
+    for data := range in {
+        for _, out := range outs {
+            out <- data
+        }
+    }
+}
+
+// dispatchCopyJobs turns metadata into CopyJobs and feeds the worker pool.
+// wg.Add happens before the job is handed to the jobs channel so wg.Wait
+// can never race with a job that hasn't been counted yet.
+func dispatchCopyJobs(in <-chan FileMetadata, jobs chan<- CopyJob, toDir string, copyFilesFlag bool, wg *sync.WaitGroup) {
+    defer close(jobs)
+    for data := range in {
+        if !copyFilesFlag {
+            continue
+        }
+        job := CopyJob{
+            UID:         data.uid,
+            Source:      data.Path,
+            Destination: filepath.Join(toDir, fmt.Sprint(data.uid)+"_"+data.Name),
+        }
+        wg.Add(1)
+        jobs <- job
+    }
+}
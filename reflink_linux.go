@@ -0,0 +1,39 @@
+//go:build linux
+
+package main
+
+import (
+    "os"
+
+    "golang.org/x/sys/unix"
+)
+
+// tryReflink asks the filesystem for a copy-on-write clone of src at dst.
+// It tries copy_file_range first (works on overlayfs/NFS in addition to
+// btrfs/XFS) and falls back to the FICLONE ioctl before giving up.
+func tryReflink(src string, dst string, sourceStat os.FileInfo) (int64, error) {
+    source, err := os.Open(src)
+    if err != nil {
+        return 0, err
+    }
+    defer source.Close()
+
+    destination, err := os.Create(dst)
+    if err != nil {
+        return 0, err
+    }
+    defer destination.Close()
+
+    size := sourceStat.Size()
+    n, err := unix.CopyFileRange(int(source.Fd()), nil, int(destination.Fd()), nil, int(size), 0)
+    if err == nil && int64(n) == size {
+        return int64(n), nil
+    }
+
+    if err := unix.IoctlFileClone(int(destination.Fd()), int(source.Fd())); err != nil {
+        os.Remove(dst)
+        return 0, err
+    }
+
+    return size, nil
+}
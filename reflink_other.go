@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+    "fmt"
+    "os"
+)
+
+// tryReflink is unavailable outside Linux; callers fall back to a byte copy.
+func tryReflink(src string, dst string, sourceStat os.FileInfo) (int64, error) {
+    return 0, fmt.Errorf("reflink is not supported on this platform")
+}
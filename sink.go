@@ -0,0 +1,218 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "os"
+    "strings"
+    "time"
+
+    _ "modernc.org/sqlite"
+)
+
+// OutputSink is a destination for the FileMetadata records discovered by the
+// walker. Implementations are written to concurrently from the streaming
+// pipeline, so WriteRecord must be safe to call from a single goroutine at a
+// time (the pipeline only ever has one sink-writer goroutine, but a future
+// caller fanning out to multiple sinks at once should keep that in mind).
+type OutputSink interface {
+    WriteHeader() error
+    WriteRecord(FileMetadata) error
+    Close() error
+}
+
+func NewOutputSink(kind string, path string) (OutputSink, error) {
+    switch strings.ToLower(kind) {
+    case "", "stdout":
+        return &stdoutSink{}, nil
+    case "none":
+        return &noopSink{}, nil
+    case "csv":
+        return newCSVSink(path)
+    case "jsonl":
+        return newJSONLSink(path)
+    case "sqlite":
+        return newSQLiteSink(path)
+    case "parquet":
+        return nil, fmt.Errorf("parquet output is not implemented yet")
+    default:
+        return nil, fmt.Errorf("unknown output sink %q (want csv|jsonl|sqlite|stdout)", kind)
+    }
+}
+
+type noopSink struct{}
+
+func (s *noopSink) WriteHeader() error             { return nil }
+func (s *noopSink) WriteRecord(_ FileMetadata) error { return nil }
+func (s *noopSink) Close() error                   { return nil }
+
+type stdoutSink struct{}
+
+func (s *stdoutSink) WriteHeader() error {
+    _, err := fmt.Printf("UID\tName\tExtension\tModDate\tIsDir\tSize(B)\tFilePath\tIsRegularfile\n")
+    return err
+}
+
+func (s *stdoutSink) WriteRecord(data FileMetadata) error {
+    _, err := fmt.Printf("%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+        data.uid, data.Name, data.Ext, data.ModDate, data.IsDir, data.Size, data.Path, data.IsReg)
+    return err
+}
+
+func (s *stdoutSink) Close() error { return nil }
+
+type csvSink struct {
+    file   *os.File
+    writer *csv.Writer
+}
+
+func newCSVSink(path string) (*csvSink, error) {
+    if path == "" {
+        path = "./output.csv"
+    }
+    file, err := os.Create(path)
+    if err != nil {
+        return nil, err
+    }
+    return &csvSink{file: file, writer: csv.NewWriter(file)}, nil
+}
+
+func (s *csvSink) WriteHeader() error {
+    return s.writer.Write([]string{"UID", "Name", "Extension", "ModDate", "IsDir", "Size(B)", "FilePath", "IsRegularFile"})
+}
+
+func (s *csvSink) WriteRecord(data FileMetadata) error {
+    return s.writer.Write([]string{
+        fmt.Sprintf("%d", data.uid),
+        data.Name,
+        data.Ext,
+        fmt.Sprintf("%v", data.ModDate),
+        fmt.Sprintf("%v", data.IsDir),
+        fmt.Sprintf("%v", data.Size),
+        data.Path,
+        fmt.Sprintf("%v", data.IsReg),
+    })
+}
+
+func (s *csvSink) Close() error {
+    s.writer.Flush()
+    if err := s.writer.Error(); err != nil {
+        return err
+    }
+    return s.file.Close()
+}
+
+// jsonlRecord is the on-disk shape of a FileMetadata record. It mirrors
+// FileMetadata's fields but with json tags, since FileMetadata.uid is
+// unexported and its field names aren't the ones we want on the wire.
+type jsonlRecord struct {
+    UID     int64     `json:"uid"`
+    Name    string    `json:"name"`
+    Ext     string    `json:"ext"`
+    ModDate time.Time `json:"modDate"`
+    IsDir   bool      `json:"isDir"`
+    Size    int64     `json:"size"`
+    Path    string    `json:"path"`
+    IsReg   bool      `json:"isReg"`
+}
+
+// jsonTextSequenceRS is the ASCII record separator RFC 7464 requires before
+// each JSON text in the sequence.
+const jsonTextSequenceRS = '\x1e'
+
+type jsonlSink struct {
+    file *os.File
+    enc  *json.Encoder
+}
+
+func newJSONLSink(path string) (*jsonlSink, error) {
+    if path == "" {
+        path = "./output.jsonl"
+    }
+    file, err := os.Create(path)
+    if err != nil {
+        return nil, err
+    }
+    return &jsonlSink{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (s *jsonlSink) WriteHeader() error { return nil }
+
+// WriteRecord writes one JSON text sequence record (RFC 7464): a leading
+// record-separator byte, the JSON value, then the encoder's trailing newline.
+func (s *jsonlSink) WriteRecord(data FileMetadata) error {
+    if _, err := s.file.Write([]byte{jsonTextSequenceRS}); err != nil {
+        return err
+    }
+    return s.enc.Encode(jsonlRecord{
+        UID:     data.uid,
+        Name:    data.Name,
+        Ext:     data.Ext,
+        ModDate: data.ModDate,
+        IsDir:   data.IsDir,
+        Size:    data.Size,
+        Path:    data.Path,
+        IsReg:   data.IsReg,
+    })
+}
+
+func (s *jsonlSink) Close() error { return s.file.Close() }
+
+type sqliteSink struct {
+    db   *sql.DB
+    stmt *sql.Stmt
+}
+
+func newSQLiteSink(path string) (*sqliteSink, error) {
+    if path == "" {
+        path = "./output.sqlite"
+    }
+    db, err := sql.Open("sqlite", path)
+    if err != nil {
+        return nil, err
+    }
+
+    schema := `
+        CREATE TABLE IF NOT EXISTS files (
+            uid INTEGER PRIMARY KEY,
+            name TEXT,
+            ext TEXT,
+            mod_date DATETIME,
+            is_dir BOOLEAN,
+            size INTEGER,
+            path TEXT,
+            is_reg BOOLEAN
+        );
+        CREATE INDEX IF NOT EXISTS idx_files_ext ON files(ext);
+        CREATE INDEX IF NOT EXISTS idx_files_mod_date ON files(mod_date);
+    `
+    if _, err := db.Exec(schema); err != nil {
+        db.Close()
+        return nil, err
+    }
+
+    stmt, err := db.Prepare(`INSERT INTO files (uid, name, ext, mod_date, is_dir, size, path, is_reg) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+
+    return &sqliteSink{db: db, stmt: stmt}, nil
+}
+
+func (s *sqliteSink) WriteHeader() error { return nil }
+
+func (s *sqliteSink) WriteRecord(data FileMetadata) error {
+    _, err := s.stmt.Exec(data.uid, data.Name, data.Ext, data.ModDate, data.IsDir, data.Size, data.Path, data.IsReg)
+    return err
+}
+
+func (s *sqliteSink) Close() error {
+    if err := s.stmt.Close(); err != nil {
+        s.db.Close()
+        return err
+    }
+    return s.db.Close()
+}
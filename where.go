@@ -0,0 +1,314 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+// ParseWhere compiles a small boolean expression over FileMetadata fields,
+// e.g. `ext in (.py,.go) and size > 1MB and modified_after 2024-01-01`, into
+// a Predicate. The grammar is a tiny recursive-descent one:
+//
+//	expr    := or
+//	or      := and ("or" and)*
+//	and     := not ("and" not)*
+//	not     := "not" not | primary
+//	primary := "(" expr ")"
+//	         | "modified_after" value | "modified_before" value
+//	         | ident "in" "(" value ("," value)* ")"
+//	         | ident op value
+func ParseWhere(expr string) (Predicate, error) {
+    toks, err := tokenizeWhere(expr)
+    if err != nil {
+        return nil, err
+    }
+    p := &whereParser{toks: toks}
+    pred, err := p.parseOr()
+    if err != nil {
+        return nil, err
+    }
+    if p.peek().kind != tokEOF {
+        return nil, fmt.Errorf("unexpected token %q", p.peek().text)
+    }
+    return pred, nil
+}
+
+type tokenKind int
+
+const (
+    tokEOF tokenKind = iota
+    tokLParen
+    tokRParen
+    tokComma
+    tokAnd
+    tokOr
+    tokNot
+    tokIn
+    tokOp
+    tokWord
+)
+
+type whereToken struct {
+    kind tokenKind
+    text string
+}
+
+func tokenizeWhere(input string) ([]whereToken, error) {
+    var toks []whereToken
+    i, n := 0, len(input)
+
+    for i < n {
+        c := input[i]
+        if c == ' ' || c == '\t' || c == '\n' {
+            i++
+            continue
+        }
+
+        switch c {
+        case '(':
+            toks = append(toks, whereToken{tokLParen, "("})
+            i++
+            continue
+        case ')':
+            toks = append(toks, whereToken{tokRParen, ")"})
+            i++
+            continue
+        case ',':
+            toks = append(toks, whereToken{tokComma, ","})
+            i++
+            continue
+        }
+
+        if strings.HasPrefix(input[i:], ">=") || strings.HasPrefix(input[i:], "<=") ||
+            strings.HasPrefix(input[i:], "==") || strings.HasPrefix(input[i:], "!=") {
+            toks = append(toks, whereToken{tokOp, input[i : i+2]})
+            i += 2
+            continue
+        }
+        if c == '>' || c == '<' {
+            toks = append(toks, whereToken{tokOp, string(c)})
+            i++
+            continue
+        }
+
+        start := i
+        for i < n && !strings.ContainsRune(" \t\n(),", rune(input[i])) {
+            i++
+        }
+        word := input[start:i]
+        switch strings.ToLower(word) {
+        case "and":
+            toks = append(toks, whereToken{tokAnd, word})
+        case "or":
+            toks = append(toks, whereToken{tokOr, word})
+        case "not":
+            toks = append(toks, whereToken{tokNot, word})
+        case "in":
+            toks = append(toks, whereToken{tokIn, word})
+        default:
+            toks = append(toks, whereToken{tokWord, word})
+        }
+    }
+
+    toks = append(toks, whereToken{tokEOF, ""})
+    return toks, nil
+}
+
+type whereParser struct {
+    toks []whereToken
+    pos  int
+}
+
+func (p *whereParser) peek() whereToken {
+    return p.toks[p.pos]
+}
+
+func (p *whereParser) next() whereToken {
+    t := p.toks[p.pos]
+    p.pos++
+    return t
+}
+
+func (p *whereParser) parseOr() (Predicate, error) {
+    left, err := p.parseAnd()
+    if err != nil {
+        return nil, err
+    }
+    for p.peek().kind == tokOr {
+        p.next()
+        right, err := p.parseAnd()
+        if err != nil {
+            return nil, err
+        }
+        l, r := left, right
+        left = func(d FileMetadata) bool { return l(d) || r(d) }
+    }
+    return left, nil
+}
+
+func (p *whereParser) parseAnd() (Predicate, error) {
+    left, err := p.parseNot()
+    if err != nil {
+        return nil, err
+    }
+    for p.peek().kind == tokAnd {
+        p.next()
+        right, err := p.parseNot()
+        if err != nil {
+            return nil, err
+        }
+        l, r := left, right
+        left = func(d FileMetadata) bool { return l(d) && r(d) }
+    }
+    return left, nil
+}
+
+func (p *whereParser) parseNot() (Predicate, error) {
+    if p.peek().kind == tokNot {
+        p.next()
+        inner, err := p.parseNot()
+        if err != nil {
+            return nil, err
+        }
+        return func(d FileMetadata) bool { return !inner(d) }, nil
+    }
+    return p.parsePrimary()
+}
+
+func (p *whereParser) parsePrimary() (Predicate, error) {
+    if p.peek().kind == tokLParen {
+        p.next()
+        inner, err := p.parseOr()
+        if err != nil {
+            return nil, err
+        }
+        if p.peek().kind != tokRParen {
+            return nil, fmt.Errorf("expected ')'")
+        }
+        p.next()
+        return inner, nil
+    }
+
+    fieldTok := p.next()
+    if fieldTok.kind != tokWord {
+        return nil, fmt.Errorf("expected a field name, got %q", fieldTok.text)
+    }
+    field := strings.ToLower(fieldTok.text)
+
+    switch field {
+    case "modified_after", "modified_before":
+        valueTok := p.next()
+        if valueTok.kind != tokWord {
+            return nil, fmt.Errorf("expected a date after %q", field)
+        }
+        t, err := parseTimeArg(valueTok.text)
+        if err != nil {
+            return nil, err
+        }
+        if field == "modified_after" {
+            return func(d FileMetadata) bool { return d.ModDate.After(t) }, nil
+        }
+        return func(d FileMetadata) bool { return d.ModDate.Before(t) }, nil
+    }
+
+    if p.peek().kind == tokIn {
+        p.next()
+        if p.peek().kind != tokLParen {
+            return nil, fmt.Errorf("expected '(' after 'in'")
+        }
+        p.next()
+
+        values := make(map[string]bool)
+        for {
+            v := p.next()
+            if v.kind != tokWord {
+                return nil, fmt.Errorf("expected a value in the 'in (...)' list")
+            }
+            values[strings.ToLower(v.text)] = true
+            if p.peek().kind == tokComma {
+                p.next()
+                continue
+            }
+            break
+        }
+        if p.peek().kind != tokRParen {
+            return nil, fmt.Errorf("expected ')' to close the 'in' list")
+        }
+        p.next()
+
+        return func(d FileMetadata) bool {
+            return values[strings.ToLower(fieldStringValue(d, field))]
+        }, nil
+    }
+
+    opTok := p.next()
+    if opTok.kind != tokOp {
+        return nil, fmt.Errorf("expected a comparison operator after %q, got %q", field, opTok.text)
+    }
+    valueTok := p.next()
+    if valueTok.kind != tokWord {
+        return nil, fmt.Errorf("expected a value after %q", opTok.text)
+    }
+
+    if field == "size" {
+        n, err := parseSize(valueTok.text)
+        if err != nil {
+            return nil, err
+        }
+        op := opTok.text
+        return func(d FileMetadata) bool { return compareInt64(op, d.Size, n) }, nil
+    }
+
+    op, want := opTok.text, valueTok.text
+    if op != "==" && op != "!=" {
+        return nil, fmt.Errorf("operator %q is not supported for the %q field (only == and != apply to string fields)", op, field)
+    }
+    return func(d FileMetadata) bool {
+        return compareString(op, fieldStringValue(d, field), want)
+    }, nil
+}
+
+func fieldStringValue(d FileMetadata, field string) string {
+    switch field {
+    case "ext":
+        return d.Ext
+    case "name":
+        return d.Name
+    case "path":
+        return d.Path
+    default:
+        return ""
+    }
+}
+
+func compareInt64(op string, a, b int64) bool {
+    switch op {
+    case ">":
+        return a > b
+    case ">=":
+        return a >= b
+    case "<":
+        return a < b
+    case "<=":
+        return a <= b
+    case "==":
+        return a == b
+    case "!=":
+        return a != b
+    default:
+        return false
+    }
+}
+
+// compareString only ever sees "==" or "!=": parsePrimary rejects any other
+// operator against a string field before building the predicate.
+func compareString(op, a, b string) bool {
+    switch op {
+    case "==":
+        return strings.EqualFold(a, b)
+    case "!=":
+        return !strings.EqualFold(a, b)
+    default:
+        return false
+    }
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseWhereBasicExpressions(t *testing.T) {
+    file := FileMetadata{
+        Name:    "report.py",
+        Ext:     ".py",
+        Path:    "/data/report.py",
+        Size:    2048,
+        ModDate: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+    }
+
+    cases := []struct {
+        expr string
+        want bool
+    }{
+        {`ext == .py`, true},
+        {`ext != .py`, false},
+        {`ext in (.py,.go)`, true},
+        {`size > 1024`, true},
+        {`size <= 1024`, false},
+        {`name == report.py and size > 1024`, true},
+        {`name == nope.py or size > 1024`, true},
+        {`not (ext == .go)`, true},
+        {`modified_after 2024-01-01`, true},
+        {`modified_before 2024-01-01`, false},
+    }
+
+    for _, c := range cases {
+        pred, err := ParseWhere(c.expr)
+        if err != nil {
+            t.Fatalf("ParseWhere(%q) returned error: %v", c.expr, err)
+        }
+        if got := pred(file); got != c.want {
+            t.Errorf("ParseWhere(%q)(file) = %v, want %v", c.expr, got, c.want)
+        }
+    }
+}
+
+func TestParseWhereRejectsUnsupportedStringOperator(t *testing.T) {
+    for _, expr := range []string{`name > z`, `ext < m`, `path >= x`} {
+        if _, err := ParseWhere(expr); err == nil {
+            t.Errorf("ParseWhere(%q) = nil error, want an error for an unsupported string operator", expr)
+        }
+    }
+}
+
+func TestParseWhereSyntaxErrors(t *testing.T) {
+    for _, expr := range []string{`(ext == .py`, `ext ==`, `ext`} {
+        if _, err := ParseWhere(expr); err == nil {
+            t.Errorf("ParseWhere(%q) = nil error, want a parse error", expr)
+        }
+    }
+}